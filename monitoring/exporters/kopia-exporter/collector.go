@@ -0,0 +1,163 @@
+package main
+
+import (
+    "errors"
+    "log"
+    "sync"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var errNotConnected = errors.New("repository not connected")
+
+// repoTarget pairs a repository's config with its already-connected client.
+// client is nil when the initial connection attempt failed; the collector
+// then just reports it as disconnected on every scrape.
+type repoTarget struct {
+    cfg    RepositoryConfig
+    client *kopiaClient
+}
+
+// kopiaCollector implements prometheus.Collector, gathering fresh metrics
+// from every configured repository on each scrape instead of from a
+// background ticker. This removes the up-to-60s staleness window the
+// previous GaugeVec-based implementation had.
+type kopiaCollector struct {
+    repos []repoTarget
+}
+
+var (
+    backupStatusDesc = prometheus.NewDesc(
+        "kopia_backup_status", "Status of the last backup (0=error, 1=success)",
+        []string{"repository", "source"}, nil,
+    )
+    backupSizeDesc = prometheus.NewDesc(
+        "kopia_backup_size_bytes", "Size of the last backup in bytes",
+        []string{"repository", "source"}, nil,
+    )
+    lastBackupTimeDesc = prometheus.NewDesc(
+        "kopia_last_backup_timestamp", "Timestamp of the last backup",
+        []string{"repository", "source"}, nil,
+    )
+    repoStatusDesc = prometheus.NewDesc(
+        "kopia_repository_status", "Repository connection status (0=disconnected, 1=connected)",
+        []string{"repository"}, nil,
+    )
+    snapshotCountDesc = prometheus.NewDesc(
+        "kopia_snapshot_count", "Number of snapshots known for a source",
+        []string{"repository", "source"}, nil,
+    )
+    snapshotOldestDesc = prometheus.NewDesc(
+        "kopia_snapshot_oldest_timestamp", "Timestamp of the oldest snapshot for a source",
+        []string{"repository", "source"}, nil,
+    )
+    snapshotFilesDesc = prometheus.NewDesc(
+        "kopia_snapshot_files_total", "Total files recorded in the latest snapshot for a source",
+        []string{"repository", "source"}, nil,
+    )
+    // No kopia_snapshot_hashed_bytes/kopia_snapshot_cached_bytes here: see
+    // the doc comment on SnapshotInfo in kopia.go for why committed
+    // snapshot manifests can't supply that data.
+    repoContentBytesDesc = prometheus.NewDesc(
+        "kopia_repository_content_bytes", "Repository content size in bytes by content type",
+        []string{"repository", "type"}, nil,
+    )
+    policyRetentionKeepDesc = prometheus.NewDesc(
+        "kopia_policy_retention_keep", "Number of snapshots the global retention policy keeps per granularity",
+        []string{"repository", "source", "granularity"}, nil,
+    )
+)
+
+func (c *kopiaCollector) Describe(ch chan<- *prometheus.Desc) {
+    ch <- backupStatusDesc
+    ch <- backupSizeDesc
+    ch <- lastBackupTimeDesc
+    ch <- repoStatusDesc
+    ch <- snapshotCountDesc
+    ch <- snapshotOldestDesc
+    ch <- snapshotFilesDesc
+    ch <- repoContentBytesDesc
+    ch <- policyRetentionKeepDesc
+}
+
+func (c *kopiaCollector) Collect(ch chan<- prometheus.Metric) {
+    var wg sync.WaitGroup
+    for _, target := range c.repos {
+        wg.Add(1)
+        go func(target repoTarget) {
+            defer wg.Done()
+            c.collectRepository(ch, target)
+        }(target)
+    }
+    wg.Wait()
+}
+
+func (c *kopiaCollector) collectRepository(ch chan<- prometheus.Metric, target repoTarget) {
+    cfg, client := target.cfg, target.client
+
+    if client == nil {
+        ch <- prometheus.MustNewConstMetric(repoStatusDesc, prometheus.GaugeValue, 0, cfg.Name)
+        sendHeartbeats(cfg.Name, nil, errNotConnected)
+        return
+    }
+
+    snapshots, err := client.ListSnapshots()
+    if err != nil {
+        log.Printf("[%s] Error listing snapshots: %v", cfg.Name, err)
+        ch <- prometheus.MustNewConstMetric(repoStatusDesc, prometheus.GaugeValue, 0, cfg.Name)
+        ch <- prometheus.MustNewConstMetric(backupStatusDesc, prometheus.GaugeValue, 0, cfg.Name, "default")
+        sendHeartbeats(cfg.Name, nil, err)
+        return
+    }
+
+    ch <- prometheus.MustNewConstMetric(repoStatusDesc, prometheus.GaugeValue, 1, cfg.Name)
+
+    bySource := map[string][]SnapshotInfo{}
+    for _, s := range snapshots {
+        source := cfg.Labels[s.Source]
+        if source == "" {
+            source = s.Source
+        }
+        bySource[source] = append(bySource[source], s)
+
+        ch <- prometheus.MustNewConstMetric(backupStatusDesc, prometheus.GaugeValue, 1, cfg.Name, source)
+        ch <- prometheus.MustNewConstMetric(backupSizeDesc, prometheus.GaugeValue, float64(s.Size), cfg.Name, source)
+        ch <- prometheus.MustNewConstMetric(lastBackupTimeDesc, prometheus.GaugeValue, float64(s.EndTime.Unix()), cfg.Name, source)
+    }
+
+    retention, err := policyRetention(client)
+    if err != nil {
+        log.Printf("[%s] Error fetching retention policy: %v", cfg.Name, err)
+    }
+
+    for source, snaps := range bySource {
+        oldest := snaps[0].EndTime
+        latest := snaps[0]
+        for _, s := range snaps {
+            if s.EndTime.Before(oldest) {
+                oldest = s.EndTime
+            }
+            if s.EndTime.After(latest.EndTime) {
+                latest = s
+            }
+        }
+
+        ch <- prometheus.MustNewConstMetric(snapshotCountDesc, prometheus.GaugeValue, float64(len(snaps)), cfg.Name, source)
+        ch <- prometheus.MustNewConstMetric(snapshotOldestDesc, prometheus.GaugeValue, float64(oldest.Unix()), cfg.Name, source)
+        ch <- prometheus.MustNewConstMetric(snapshotFilesDesc, prometheus.GaugeValue, float64(latest.FileCount), cfg.Name, source)
+
+        for granularity, keep := range retention {
+            ch <- prometheus.MustNewConstMetric(policyRetentionKeepDesc, prometheus.GaugeValue, float64(keep), cfg.Name, source, granularity)
+        }
+    }
+
+    if stats, err := contentStats(client); err != nil {
+        log.Printf("[%s] Error fetching content stats: %v", cfg.Name, err)
+    } else {
+        for contentType, bytes := range stats {
+            ch <- prometheus.MustNewConstMetric(repoContentBytesDesc, prometheus.GaugeValue, float64(bytes), cfg.Name, contentType)
+        }
+    }
+
+    sendHeartbeats(cfg.Name, snapshots, nil)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "strings"
+
+    "gopkg.in/yaml.v3"
+)
+
+// ExporterConfig describes a fleet of kopia repositories to scrape. It is
+// loaded from --config / KOPIA_EXPORTER_CONFIG; when neither is set the
+// exporter falls back to a single legacy repository built from
+// KOPIA_SERVER_URL / KOPIA_PASSWORD.
+type ExporterConfig struct {
+    Repositories []RepositoryConfig `yaml:"repositories"`
+}
+
+// RepositoryConfig is one kopia server to connect to. Name becomes the
+// "repository" label on every metric the exporter produces for it. Unlike
+// the original design, scrape cadence is no longer configured here: the
+// collector gathers fresh metrics from the repository on every /metrics
+// scrape, so cadence is controlled by Prometheus's own scrape_interval.
+type RepositoryConfig struct {
+    Name               string            `yaml:"name"`
+    ServerURL          string            `yaml:"serverURL"`
+    Password           PasswordSource    `yaml:"password"`
+    TLSCertFingerprint string            `yaml:"tlsCertFingerprint"`
+    ConfigDir          string            `yaml:"configDir"`
+    Labels             map[string]string `yaml:"labels"`
+}
+
+// PasswordSource resolves a repository password from exactly one of an env
+// var, a file on disk, or a Kubernetes secret reference.
+type PasswordSource struct {
+    Env       string        `yaml:"env"`
+    File      string        `yaml:"file"`
+    SecretRef *K8sSecretRef `yaml:"secretRef"`
+}
+
+// K8sSecretRef points at a key inside a Kubernetes Secret, read from the
+// projected secret volume mounted at
+// /var/run/secrets/kopia-exporter/<name>/<key> - the exporter never talks
+// to the Kubernetes API server directly.
+type K8sSecretRef struct {
+    Namespace string `yaml:"namespace"`
+    Name      string `yaml:"name"`
+    Key       string `yaml:"key"`
+}
+
+// Resolve returns the plaintext password for a repository, reading it from
+// whichever source was configured.
+func (p PasswordSource) Resolve() (string, error) {
+    switch {
+    case p.Env != "":
+        v := os.Getenv(p.Env)
+        if v == "" {
+            return "", fmt.Errorf("env %s is empty", p.Env)
+        }
+        return v, nil
+    case p.File != "":
+        data, err := os.ReadFile(p.File)
+        if err != nil {
+            return "", fmt.Errorf("reading password file %s: %w", p.File, err)
+        }
+        return strings.TrimSpace(string(data)), nil
+    case p.SecretRef != nil:
+        path := fmt.Sprintf("/var/run/secrets/kopia-exporter/%s/%s", p.SecretRef.Name, p.SecretRef.Key)
+        data, err := os.ReadFile(path)
+        if err != nil {
+            return "", fmt.Errorf("reading secret %s/%s: %w", p.SecretRef.Namespace, p.SecretRef.Name, err)
+        }
+        return strings.TrimSpace(string(data)), nil
+    default:
+        return "", fmt.Errorf("password source not configured")
+    }
+}
+
+// loadExporterConfig reads the YAML config at path and checks that every
+// repository has a name. It fills in no defaults; optional fields are left
+// as parsed.
+func loadExporterConfig(path string) (*ExporterConfig, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("reading exporter config %s: %w", path, err)
+    }
+
+    var cfg ExporterConfig
+    if err := yaml.Unmarshal(data, &cfg); err != nil {
+        return nil, fmt.Errorf("parsing exporter config: %w", err)
+    }
+
+    for i := range cfg.Repositories {
+        if cfg.Repositories[i].Name == "" {
+            return nil, fmt.Errorf("repositories[%d]: name is required", i)
+        }
+    }
+
+    return &cfg, nil
+}
+
+// legacyRepositoryConfig builds a single-repository config from the
+// pre-chunk0-3 environment variables, for deployments that haven't
+// migrated to a config file yet.
+func legacyRepositoryConfig() RepositoryConfig {
+    serverURL := os.Getenv("KOPIA_SERVER_URL")
+    if serverURL == "" {
+        serverURL = "http://kopia-server:51515"
+    }
+
+    return RepositoryConfig{
+        Name:      "default",
+        ServerURL: serverURL,
+        Password:  PasswordSource{Env: "KOPIA_PASSWORD"},
+        ConfigDir: os.Getenv("KOPIA_CONFIG_PATH"),
+    }
+}
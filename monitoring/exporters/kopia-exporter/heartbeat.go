@@ -0,0 +1,113 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+)
+
+// heartbeatPayload summarizes one repository's collection pass for the
+// configured heartbeat/dead-man-switch endpoints.
+type heartbeatPayload struct {
+    Repository       string  `json:"repository"`
+    SnapshotCount    int     `json:"snapshot_count"`
+    OldestSnapshotAt float64 `json:"oldest_snapshot_age_seconds"`
+    Status           string  `json:"status"`
+}
+
+// heartbeatURLs is the configured list of ping targets, parsed once from
+// KOPIA_HEARTBEAT_URLS (comma-separated). When the scrape found an unhealthy
+// repository, "/fail" (healthchecks.io-style) is appended to every URL and
+// that is pinged instead of the base URL.
+var heartbeatURLs []string
+
+// heartbeatStaleAfter is how old the oldest snapshot may get before the
+// exporter considers the repository stale, in addition to any hard errors.
+var heartbeatStaleAfter time.Duration
+
+func init() {
+    if raw := os.Getenv("KOPIA_HEARTBEAT_URLS"); raw != "" {
+        for _, u := range strings.Split(raw, ",") {
+            if u = strings.TrimSpace(u); u != "" {
+                heartbeatURLs = append(heartbeatURLs, u)
+            }
+        }
+    }
+
+    heartbeatStaleAfter = 24 * time.Hour
+    if raw := os.Getenv("KOPIA_HEARTBEAT_STALE_AFTER"); raw != "" {
+        if d, err := time.ParseDuration(raw); err == nil {
+            heartbeatStaleAfter = d
+        } else {
+            log.Printf("Invalid KOPIA_HEARTBEAT_STALE_AFTER %q, keeping default %s: %v", raw, heartbeatStaleAfter, err)
+        }
+    }
+}
+
+// sendHeartbeats pings every configured heartbeat URL with the outcome of
+// one repository's collectRepository pass. A URL that can't be reached is logged and otherwise
+// ignored - a flaky heartbeat provider must never take the exporter down.
+func sendHeartbeats(repository string, snapshots []SnapshotInfo, scrapeErr error) {
+    if len(heartbeatURLs) == 0 {
+        return
+    }
+
+    healthy := scrapeErr == nil
+    oldestAge := 0.0
+
+    if healthy {
+        for _, s := range snapshots {
+            if s.EndTime.IsZero() {
+                continue
+            }
+            age := time.Since(s.EndTime)
+            if age.Seconds() > oldestAge {
+                oldestAge = age.Seconds()
+            }
+        }
+        if time.Duration(oldestAge*float64(time.Second)) > heartbeatStaleAfter {
+            healthy = false
+        }
+    }
+
+    status := "ok"
+    if !healthy {
+        status = "error"
+    }
+
+    payload, err := json.Marshal(heartbeatPayload{
+        Repository:       repository,
+        SnapshotCount:    len(snapshots),
+        OldestSnapshotAt: oldestAge,
+        Status:           status,
+    })
+    if err != nil {
+        log.Printf("Error marshaling heartbeat payload: %v", err)
+        return
+    }
+
+    for _, base := range heartbeatURLs {
+        url := base
+        if !healthy {
+            url = strings.TrimRight(base, "/") + "/fail"
+        }
+        pingHeartbeatURL(url, payload)
+    }
+}
+
+func pingHeartbeatURL(url string, payload []byte) {
+    resp, err := http.Post(url, "application/json", bytes.NewReader(payload))
+    if err != nil {
+        log.Printf("Error pinging heartbeat URL %s: %v", url, err)
+        return
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode >= 300 {
+        log.Printf("Heartbeat URL %s returned status %s", url, resp.Status)
+    }
+}
@@ -1,67 +1,31 @@
 package main
 
 import (
-    "encoding/json"
+    "flag"
     "log"
     "net/http"
     "os"
-    "os/exec"
-    "time"
 
     "github.com/prometheus/client_golang/prometheus"
     "github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var (
-    backupStatus = prometheus.NewGaugeVec(
-        prometheus.GaugeOpts{
-            Name: "kopia_backup_status",
-            Help: "Status of the last backup (0=error, 1=success)",
-        },
-        []string{"source"},
-    )
-
-    backupSize = prometheus.NewGaugeVec(
-        prometheus.GaugeOpts{
-            Name: "kopia_backup_size_bytes",
-            Help: "Size of the last backup in bytes",
-        },
-        []string{"source"},
-    )
-
-    lastBackupTime = prometheus.NewGaugeVec(
-        prometheus.GaugeOpts{
-            Name: "kopia_last_backup_timestamp",
-            Help: "Timestamp of the last backup",
-        },
-        []string{"source"},
-    )
-
-    repoStatus = prometheus.NewGauge(
-        prometheus.GaugeOpts{
-            Name: "kopia_repository_status",
-            Help: "Repository connection status (0=disconnected, 1=connected)",
-        },
-    )
-)
+// cliMode shells out to the kopia binary instead of opening the repository
+// with the native Go API. Use it in environments that pin a specific kopia
+// release, since the native path links against that exact repo format.
+var cliMode bool
 
-func init() {
-    prometheus.MustRegister(backupStatus)
-    prometheus.MustRegister(backupSize)
-    prometheus.MustRegister(lastBackupTime)
-    prometheus.MustRegister(repoStatus)
-}
+// configFile points at the YAML fleet config (--config / KOPIA_EXPORTER_CONFIG).
+// When empty the exporter falls back to a single legacy repository built
+// from KOPIA_SERVER_URL / KOPIA_PASSWORD.
+var configFile string
 
-type SnapshotInfo struct {
-    ID        string    `json:"id"`
-    Source    string    `json:"source"`
-    StartTime time.Time `json:"startTime"`
-    EndTime   time.Time `json:"endTime"`
-    Size      int64     `json:"size"`
+func init() {
+    flag.BoolVar(&cliMode, "cli-mode", os.Getenv("KOPIA_CLI_MODE") == "true", "shell out to the kopia CLI instead of using the native repo API")
+    flag.StringVar(&configFile, "config", os.Getenv("KOPIA_EXPORTER_CONFIG"), "path to a YAML config describing the repositories to scrape")
 }
 
-func setupKopiaConfig() error {
-    configPath := os.Getenv("KOPIA_CONFIG_PATH")
+func setupKopiaConfig(configPath string) error {
     if configPath == "" {
         configPath = "/app/config"
     }
@@ -85,66 +49,57 @@ func setupKopiaConfig() error {
 }
 
 func main() {
-    if err := setupKopiaConfig(); err != nil {
-        log.Fatalf("Error setting up config: %v", err)
-    }
+    flag.Parse()
 
-    // Получаем параметры подключения из переменных окружения
-    serverURL := os.Getenv("KOPIA_SERVER_URL")
-    if serverURL == "" {
-        serverURL = "http://kopia-server:51515"
+    var repos []RepositoryConfig
+    if configFile != "" {
+        cfg, err := loadExporterConfig(configFile)
+        if err != nil {
+            log.Fatalf("Error loading exporter config: %v", err)
+        }
+        repos = cfg.Repositories
+    } else {
+        repos = []RepositoryConfig{legacyRepositoryConfig()}
     }
 
-    password := os.Getenv("KOPIA_PASSWORD")
-    if password == "" {
-        log.Fatal("KOPIA_PASSWORD environment variable is required")
+    targets := make([]repoTarget, 0, len(repos))
+    for _, repoCfg := range repos {
+        targets = append(targets, connectRepository(repoCfg))
     }
 
-    // Пробуем подключиться к серверу
-    connectCmd := exec.Command("kopia", "repository", "connect", "server",
-        "--url", serverURL,
-        "--password", password,
-        "--no-check-for-updates",
-        "--no-progress")
-
-    if output, err := connectCmd.CombinedOutput(); err != nil {
-        log.Printf("Error connecting to Kopia server: %v\nOutput: %s", err, output)
-        repoStatus.Set(0)
-    } else {
-        log.Printf("Successfully connected to Kopia server")
-        repoStatus.Set(1)
+    if os.Getenv("KOPIA_EXPORTER_MODE") == "push" {
+        os.Exit(runPushMode(targets))
     }
 
+    prometheus.MustRegister(&kopiaCollector{repos: targets})
+
     http.Handle("/metrics", promhttp.Handler())
-    go collectMetrics()
-    log.Printf("Starting Kopia exporter on :9091")
+    log.Printf("Starting Kopia exporter on :9091 (repositories=%d)", len(targets))
     log.Fatal(http.ListenAndServe(":9091", nil))
 }
 
-func collectMetrics() {
-    for {
-        cmd := exec.Command("kopia", "snapshot", "list", "--json", "--no-progress")
-        output, err := cmd.CombinedOutput()
-        if err != nil {
-            log.Printf("Error executing kopia: %v\nOutput: %s", err, output)
-            backupStatus.WithLabelValues("default").Set(0)
-            repoStatus.Set(0)
-        } else {
-            var snapshots []SnapshotInfo
-            if err := json.Unmarshal(output, &snapshots); err != nil {
-                log.Printf("Error parsing JSON: %v", err)
-                continue
-            }
+// connectRepository sets up the config directory and opens the kopia
+// connection for one configured repository. A connection failure is not
+// fatal: the collector reports kopia_repository_status=0 for it on every
+// scrape instead of crash-looping the whole exporter.
+func connectRepository(repoCfg RepositoryConfig) repoTarget {
+    if err := setupKopiaConfig(repoCfg.ConfigDir); err != nil {
+        log.Printf("[%s] Error setting up config: %v", repoCfg.Name, err)
+        return repoTarget{cfg: repoCfg}
+    }
 
-            repoStatus.Set(1)
-            // Обработка каждого снапшота
-            for _, snapshot := range snapshots {
-                source := snapshot.Source
-                backupStatus.WithLabelValues(source).Set(1)
-                backupSize.WithLabelValues(source).Set(float64(snapshot.Size))
-                lastBackupTime.WithLabelValues(source).Set(float64(snapshot.EndTime.Unix()))
-            }
-        }
-        time.Sleep(60 * time.Second)
+    password, err := repoCfg.Password.Resolve()
+    if err != nil {
+        log.Printf("[%s] Error resolving repository password: %v", repoCfg.Name, err)
+        return repoTarget{cfg: repoCfg}
+    }
+
+    client, err := connectKopia(repoCfg.ServerURL, password, cliMode, repoCfg.ConfigDir, repoCfg.TLSCertFingerprint)
+    if err != nil {
+        log.Printf("[%s] Error connecting to Kopia server: %v", repoCfg.Name, err)
+        return repoTarget{cfg: repoCfg}
     }
-} 
\ No newline at end of file
+
+    log.Printf("[%s] Successfully connected to Kopia server (cli-mode=%v)", repoCfg.Name, cliMode)
+    return repoTarget{cfg: repoCfg, client: client}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+    "log"
+    "os"
+    "strconv"
+    "strings"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/push"
+)
+
+// runPushMode gathers one scrape's worth of metrics from targets and pushes
+// them to a Pushgateway, for ephemeral backup jobs that the pull-based
+// /metrics endpoint would otherwise never catch running. It returns the
+// process exit code the caller should use, mirroring the wrapped snapshot
+// job's own exit code so cron/CI wrappers still fail the pipeline on a bad
+// backup even though this process pushed successfully.
+func runPushMode(targets []repoTarget) int {
+    gatewayURL := os.Getenv("KOPIA_PUSHGATEWAY_URL")
+    if gatewayURL == "" {
+        log.Fatal("KOPIA_PUSHGATEWAY_URL environment variable is required in push mode")
+    }
+
+    jobName := os.Getenv("KOPIA_PUSH_JOB")
+    if jobName == "" {
+        jobName = "kopia_backup"
+    }
+
+    registry := prometheus.NewRegistry()
+    registry.MustRegister(&kopiaCollector{repos: targets})
+
+    pusher := push.New(gatewayURL, jobName).Gatherer(registry)
+    for name, value := range parseGroupingLabels(os.Getenv("KOPIA_PUSH_GROUPING_KEY")) {
+        pusher = pusher.Grouping(name, value)
+    }
+
+    if err := pusher.Push(); err != nil {
+        log.Printf("Error pushing metrics to %s: %v", gatewayURL, err)
+    }
+
+    return exitCodeFromEnv()
+}
+
+// parseGroupingLabels turns a comma-separated key=value list (e.g.
+// "instance=host1,job=nightly") into a grouping key map for the pusher.
+func parseGroupingLabels(raw string) map[string]string {
+    labels := map[string]string{}
+    if raw == "" {
+        return labels
+    }
+    for _, pair := range strings.Split(raw, ",") {
+        kv := strings.SplitN(pair, "=", 2)
+        if len(kv) != 2 {
+            continue
+        }
+        labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+    }
+    return labels
+}
+
+// exitCodeFromEnv reads the wrapped backup job's exit code so this process
+// can propagate it after pushing metrics.
+func exitCodeFromEnv() int {
+    raw := os.Getenv("KOPIA_SNAPSHOT_EXIT_CODE")
+    if raw == "" {
+        return 0
+    }
+    code, err := strconv.Atoi(raw)
+    if err != nil {
+        log.Printf("Invalid KOPIA_SNAPSHOT_EXIT_CODE %q, defaulting to 0: %v", raw, err)
+        return 0
+    }
+    return code
+}